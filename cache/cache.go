@@ -0,0 +1,287 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cache provides a concurrent, cost-bounded cache built on top of
+// the queue package's lock-free RingBuffer.  Reads are sampled onto a
+// lossy ring (dropped under contention rather than blocking callers) and
+// writes are serialized onto a second ring; a single background goroutine
+// drains both to update a TinyLFU admission filter and a segmented LRU
+// eviction policy.  Get holds the cache's mutex only for the map lookup
+// and never waits on either ring, while admit/evict decisions are still
+// informed by the sampled accesses.
+package cache
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/blastbao/go-datastructures/queue"
+)
+
+// cacheEntry is what Cache actually stores per key; cost is kept
+// alongside the value so deletes and overwrites can adjust usedCost
+// without consulting the eviction policy.
+type cacheEntry struct {
+	value interface{}
+	cost  int64
+}
+
+type writeOp struct {
+	key   interface{}
+	value interface{}
+	cost  int64
+	del   bool
+}
+
+type options struct {
+	readBufferSize  uint64
+	writeBufferSize uint64
+	counters        uint64
+}
+
+// defaultCounters sizes the TinyLFU admission sketch when the caller
+// doesn't supply WithCounters. maxCost is a cost budget in
+// caller-defined units (bytes, weights, whatever Set is called with)
+// and bears no fixed relationship to how many distinct keys the cache
+// will see, so the sketch can't be sized off it directly.
+const defaultCounters = 1 << 16
+
+// maxCounters bounds how large the admission sketch can grow even if a
+// caller passes an enormous WithCounters value.
+const maxCounters = 1 << 24
+
+// Option configures optional Cache parameters passed to NewCache.
+type Option func(*options)
+
+// WithReadBufferSize sets the size of the lossy read-sample ring.
+// Defaults to 64.
+func WithReadBufferSize(n uint64) Option {
+	return func(o *options) { o.readBufferSize = n }
+}
+
+// WithWriteBufferSize sets the size of the write ring that serializes
+// Set/Del admission decisions. Defaults to 64.
+func WithWriteBufferSize(n uint64) Option {
+	return func(o *options) { o.writeBufferSize = n }
+}
+
+// WithCounters sets the expected number of distinct keys the cache will
+// see, used to size the TinyLFU admission sketch (not the cost budget,
+// which may be in entirely different units). Defaults to 65536.
+func WithCounters(n uint64) Option {
+	return func(o *options) { o.counters = n }
+}
+
+// Cache is a concurrent cache bounded by a total cost rather than a
+// fixed item count, admitting new entries only when they are estimated
+// to be accessed more frequently than the entry they would evict.
+type Cache struct {
+	maxCost  int64
+	usedCost int64
+
+	mu     sync.Mutex
+	items  map[interface{}]cacheEntry
+	policy *slru
+	sketch *cmSketch
+
+	reads  *queue.RingBuffer
+	writes *queue.RingBuffer
+
+	closeOnce sync.Once
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewCache allocates a Cache that admits at most maxCost worth of
+// entries (as measured by the cost passed to Set) and starts its
+// background drain goroutine.
+func NewCache(maxCost int64, opts ...Option) *Cache {
+	cfg := options{readBufferSize: 64, writeBufferSize: 64, counters: defaultCounters}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	counters := cfg.counters
+	if counters > maxCounters {
+		counters = maxCounters
+	}
+
+	// ParkStrategySleep backs processLoop's two Poll calls off with real
+	// time.Sleep instead of the default busy-spin, since an idle Cache
+	// otherwise pins a core for as long as it's open.
+	parkOpts := queue.RingBufferOptions{ParkStrategy: queue.ParkStrategySleep}
+	c := &Cache{
+		maxCost: maxCost,
+		items:   make(map[interface{}]cacheEntry),
+		policy:  newSLRU(maxCost * 8 / 10),
+		sketch:  newCMSketch(counters),
+		reads:   queue.NewRingBufferWithOptions(cfg.readBufferSize, parkOpts),
+		writes:  queue.NewRingBufferWithOptions(cfg.writeBufferSize, parkOpts),
+		done:    make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.processLoop()
+	return c
+}
+
+// Get returns the value stored for key, and whether it was found. A hit
+// is sampled onto the read ring so the background goroutine can update
+// the key's estimated access frequency and LRU position; under heavy
+// contention that sample may be dropped, which only makes the admission
+// filter slightly less precise, never incorrect.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.mu.Lock()
+	entry, ok := c.items[key]
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	c.reads.Offer(key)
+	return entry.value, true
+}
+
+// Set admits key/value at the given cost, asynchronously evicting
+// whatever the SLRU/TinyLFU admission policy selects to make room. Set
+// returns as soon as the request is queued; the admission decision
+// itself happens on the background goroutine.
+func (c *Cache) Set(key, value interface{}, cost int64) {
+	c.writes.Put(writeOp{key: key, value: value, cost: cost})
+}
+
+// Del removes key from the cache, if present.
+func (c *Cache) Del(key interface{}) {
+	c.writes.Put(writeOp{key: key, del: true})
+}
+
+// Close stops the background goroutine and disposes the internal rings.
+// Any Set/Del already queued but not yet applied is discarded.
+func (c *Cache) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.reads.Dispose()
+		c.writes.Dispose()
+	})
+	c.wg.Wait()
+}
+
+func (c *Cache) processLoop() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		if v, err := c.writes.Poll(time.Millisecond); err == nil {
+			c.applyWrite(v.(writeOp))
+		} else if err == queue.ErrDisposed {
+			return
+		}
+
+		if v, err := c.reads.Poll(time.Millisecond); err == nil {
+			c.applySample(v)
+		} else if err == queue.ErrDisposed {
+			return
+		}
+	}
+}
+
+func (c *Cache) applySample(key interface{}) {
+	c.sketch.Increment(keyHash(key))
+	c.mu.Lock()
+	c.policy.access(key)
+	c.mu.Unlock()
+}
+
+func (c *Cache) applyWrite(op writeOp) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if op.del {
+		if entry, ok := c.items[op.key]; ok {
+			c.usedCost -= entry.cost
+			delete(c.items, op.key)
+			c.policy.remove(op.key)
+		}
+		return
+	}
+
+	if entry, ok := c.items[op.key]; ok {
+		c.usedCost += op.cost - entry.cost
+		c.items[op.key] = cacheEntry{value: op.value, cost: op.cost}
+
+		// An update to an existing key never needs the admission
+		// comparison below (the key is already resident, so there's no
+		// candidate-vs-incumbent decision to make), but raising its
+		// cost can still push usedCost over budget, so the same
+		// eviction loop as a fresh admission still applies here,
+		// unconditionally. Other entries are evicted first; op.key
+		// itself is evicted only once nothing else is left, so an
+		// update never leaves usedCost over budget.
+		for c.usedCost > c.maxCost {
+			victim, ok := c.policy.victim(op.key)
+			if !ok {
+				victim = op.key // nothing else left to evict
+			}
+			if vEntry, ok := c.items[victim]; ok {
+				c.usedCost -= vEntry.cost
+				delete(c.items, victim)
+			}
+			c.policy.remove(victim)
+		}
+		return
+	}
+
+	for c.usedCost+op.cost > c.maxCost {
+		victim, ok := c.policy.victim(nil)
+		if !ok {
+			break // nothing left to evict; admit anyway rather than reject forever
+		}
+		if !c.admit(op.key, victim) {
+			return // candidate loses to the incumbent; reject this write
+		}
+		if entry, ok := c.items[victim]; ok {
+			c.usedCost -= entry.cost
+			delete(c.items, victim)
+		}
+		c.policy.remove(victim)
+	}
+
+	c.items[op.key] = cacheEntry{value: op.value, cost: op.cost}
+	c.usedCost += op.cost
+	c.policy.add(op.key, op.cost)
+}
+
+// admit compares the candidate's estimated frequency against the
+// victim's, favoring the victim on ties so that a flood of once-off
+// keys can't repeatedly evict a key it isn't actually hotter than.
+func (c *Cache) admit(candidate, victim interface{}) bool {
+	return c.sketch.Estimate(keyHash(candidate)) > c.sketch.Estimate(keyHash(victim))
+}
+
+// keyHash hashes an arbitrary comparable key for the sketch and eviction
+// policy. Cache keys are expected to be cheaply stringable (the common
+// case: strings and integers), so this trades a little hashing precision
+// for not requiring callers to implement a Hash method.
+func keyHash(key interface{}) uint64 {
+	h := fnv.New64a()
+	fmt.Fprint(h, key)
+	return h.Sum64()
+}
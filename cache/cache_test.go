@@ -0,0 +1,193 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitUntil polls cond every millisecond until it returns true or
+// timeout elapses, returning whether cond was ever satisfied. Set/Del
+// are applied asynchronously by the background goroutine, so tests
+// observe them this way rather than assuming immediate visibility.
+func waitUntil(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if cond() {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestCacheSetGetDel guards the basic Set/Get/Del contract: a Set must
+// eventually become visible via Get, and a Del must eventually make Get
+// report the key as absent again.
+func TestCacheSetGetDel(t *testing.T) {
+	c := NewCache(1000)
+	defer c.Close()
+
+	c.Set("a", 1, 1)
+	if !waitUntil(2*time.Second, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == 1
+	}) {
+		t.Fatal("Set value never became visible via Get")
+	}
+
+	c.Del("a")
+	if !waitUntil(2*time.Second, func() bool {
+		_, ok := c.Get("a")
+		return !ok
+	}) {
+		t.Fatal("Del never took effect")
+	}
+}
+
+// TestCacheSetOverwritesCostNotCumulative guards against applyWrite's
+// update path double-counting cost: re-Setting an existing key must
+// replace its cost in usedCost, not add to it.
+func TestCacheSetOverwritesCostNotCumulative(t *testing.T) {
+	c := NewCache(1000)
+	defer c.Close()
+
+	c.Set("a", "v1", 5)
+	if !waitUntil(2*time.Second, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == "v1"
+	}) {
+		t.Fatal("first Set never became visible")
+	}
+
+	c.Set("a", "v2", 40)
+	if !waitUntil(2*time.Second, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == "v2"
+	}) {
+		t.Fatal("second Set never became visible")
+	}
+
+	c.mu.Lock()
+	used := c.usedCost
+	c.mu.Unlock()
+	if used != 40 {
+		t.Fatalf("usedCost = %d, want 40 (the latest cost, not 5+40)", used)
+	}
+}
+
+// TestCacheUpdateRaisingCostStillEvicts guards against applyWrite's
+// update path skipping eviction enforcement entirely: re-Setting an
+// existing key at a higher cost must still trigger eviction if that
+// pushes usedCost over maxCost, not leave it stuck over budget forever.
+func TestCacheUpdateRaisingCostStillEvicts(t *testing.T) {
+	c := NewCache(10)
+	defer c.Close()
+
+	c.Set("a", "v1", 5)
+	if !waitUntil(2*time.Second, func() bool {
+		v, ok := c.Get("a")
+		return ok && v == "v1"
+	}) {
+		t.Fatal("first Set never became visible")
+	}
+
+	c.Set("b", "w", 4)
+	if !waitUntil(2*time.Second, func() bool {
+		_, ok := c.Get("b")
+		return ok
+	}) {
+		t.Fatal("second Set never became visible")
+	}
+
+	// Access "b" so the admission policy considers it hotter than "a"
+	// and prefers evicting "a" once room is needed.
+	c.Get("b")
+	time.Sleep(20 * time.Millisecond)
+
+	c.Set("a", "v2", 1000)
+	if !waitUntil(2*time.Second, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return c.usedCost <= c.maxCost
+	}) {
+		c.mu.Lock()
+		used, max := c.usedCost, c.maxCost
+		c.mu.Unlock()
+		t.Fatalf("usedCost=%d never settled back under maxCost=%d after a cost-raising update", used, max)
+	}
+}
+
+// TestCacheEvictionBoundsUsedCost guards the admission filter's core
+// promise: usedCost must never settle above maxCost, even when far more
+// cost is offered than the cache can hold.
+func TestCacheEvictionBoundsUsedCost(t *testing.T) {
+	c := NewCache(30)
+	defer c.Close()
+
+	for i := 0; i < 20; i++ {
+		c.Set(i, i, 10)
+	}
+
+	if !waitUntil(2*time.Second, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.items) > 0
+	}) {
+		t.Fatal("no write was ever admitted")
+	}
+	// Give the drain loop a little more time to work through the full
+	// backlog of writes before asserting the steady-state invariant.
+	time.Sleep(50 * time.Millisecond)
+
+	c.mu.Lock()
+	used, max := c.usedCost, c.maxCost
+	c.mu.Unlock()
+	if used > max {
+		t.Fatalf("usedCost=%d exceeds maxCost=%d after eviction", used, max)
+	}
+}
+
+// TestCacheConcurrentGetSetDelClose exercises Get/Set/Del from many
+// goroutines at once and then Close, guarding against races and
+// deadlocks in the lock-free read path and the serialized write path
+// racing the background drain goroutine and Close's Dispose calls.
+func TestCacheConcurrentGetSetDelClose(t *testing.T) {
+	c := NewCache(1000)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := g*1000 + i
+				c.Set(key, key, 1)
+				c.Get(key)
+				if i%10 == 0 {
+					c.Del(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	c.Close()
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import "container/list"
+
+// slru is a segmented LRU eviction policy: new items land in the smaller
+// probation segment, are promoted to protected on a second access, and
+// the protected segment spills its own LRU tail back into probation
+// rather than evicting it directly. This makes the policy resistant to
+// single-use scan workloads that would otherwise flush a plain LRU.
+//
+// slru only tracks ordering; it never holds the cached value itself,
+// that lives in Cache.items.
+type slru struct {
+	protectedCap  int64
+	protectedCost int64
+	protected     *list.List
+	probation     *list.List
+	index         map[interface{}]*list.Element
+}
+
+type slruEntry struct {
+	key       interface{}
+	cost      int64
+	protected bool
+}
+
+func newSLRU(protectedCap int64) *slru {
+	return &slru{
+		protectedCap: protectedCap,
+		protected:    list.New(),
+		probation:    list.New(),
+		index:        make(map[interface{}]*list.Element),
+	}
+}
+
+// add registers a freshly admitted key in the probation segment.
+func (s *slru) add(key interface{}, cost int64) {
+	e := s.probation.PushFront(&slruEntry{key: key, cost: cost})
+	s.index[key] = e
+}
+
+// remove drops key from whichever segment it's in, e.g. on Del or
+// eviction.
+func (s *slru) remove(key interface{}) {
+	e, ok := s.index[key]
+	if !ok {
+		return
+	}
+	delete(s.index, key)
+	entry := e.Value.(*slruEntry)
+	if entry.protected {
+		s.protected.Remove(e)
+		s.protectedCost -= entry.cost
+	} else {
+		s.probation.Remove(e)
+	}
+}
+
+// access records a hit against key, promoting it out of probation into
+// protected. If protected is over capacity, its own LRU tail is demoted
+// back into probation so nothing is silently dropped here.
+func (s *slru) access(key interface{}) {
+	e, ok := s.index[key]
+	if !ok {
+		return
+	}
+	entry := e.Value.(*slruEntry)
+	if entry.protected {
+		s.protected.MoveToFront(e)
+		return
+	}
+
+	s.probation.Remove(e)
+	entry.protected = true
+	s.protectedCost += entry.cost
+	s.index[key] = s.protected.PushFront(entry)
+
+	for s.protectedCost > s.protectedCap {
+		tail := s.protected.Back()
+		if tail == nil {
+			break
+		}
+		demoted := tail.Value.(*slruEntry)
+		s.protected.Remove(tail)
+		s.protectedCost -= demoted.cost
+		demoted.protected = false
+		s.index[demoted.key] = s.probation.PushFront(demoted)
+	}
+}
+
+// victim returns the key at the tail of probation (falling back to the
+// tail of protected if probation is empty), the candidate slru evicts
+// next to make room for a new admission. exclude, if non-nil, is never
+// returned even if it sits at a segment's tail; this lets a caller that
+// is itself resident and growing in cost look past itself for a real
+// victim instead of evicting the entry it's in the middle of updating.
+func (s *slru) victim(exclude interface{}) (interface{}, bool) {
+	if e := segmentVictim(s.probation, exclude); e != nil {
+		return e.Value.(*slruEntry).key, true
+	}
+	if e := segmentVictim(s.protected, exclude); e != nil {
+		return e.Value.(*slruEntry).key, true
+	}
+	return nil, false
+}
+
+// segmentVictim walks l from the tail looking for the first entry whose
+// key isn't exclude.
+func segmentVictim(l *list.List, exclude interface{}) *list.Element {
+	for e := l.Back(); e != nil; e = e.Prev() {
+		if e.Value.(*slruEntry).key != exclude {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+// cmSketch is a count-min sketch over 4-bit saturating counters, used to
+// estimate how frequently a key has been accessed without keeping an
+// exact per-key counter.  It is the admission filter behind TinyLFU: a
+// candidate key is only let into the cache if its estimated frequency
+// beats the frequency of the item it would evict.
+//
+// Counters are halved every sampleSize increments so that the sketch
+// tracks recent frequency rather than accumulating forever.
+type cmSketch struct {
+	rows       [cmDepth][]byte // each byte packs two 4-bit counters
+	mask       uint64
+	additions  uint64
+	sampleSize uint64
+}
+
+const cmDepth = 4
+
+// newCMSketch builds a sketch sized to track roughly numCounters distinct
+// keys. numCounters is rounded up to a power of 2.
+func newCMSketch(numCounters uint64) *cmSketch {
+	size := roundUpPow2(numCounters)
+	if size < 16 {
+		size = 16
+	}
+	s := &cmSketch{
+		mask:       size - 1,
+		sampleSize: size * 10,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]byte, size/2)
+	}
+	return s
+}
+
+func roundUpPow2(v uint64) uint64 {
+	if v == 0 {
+		return 1
+	}
+	v--
+	v |= v >> 1
+	v |= v >> 2
+	v |= v >> 4
+	v |= v >> 8
+	v |= v >> 16
+	v |= v >> 32
+	v++
+	return v
+}
+
+// cmSeeds decorrelates the cmDepth rows so a single hash collision in one
+// row doesn't also collide in the others.
+var cmSeeds = [cmDepth]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xff51afd7ed558ccd}
+
+func (s *cmSketch) index(row int, h uint64) uint64 {
+	h ^= h >> 33
+	h *= cmSeeds[row]
+	h ^= h >> 29
+	return h & s.mask
+}
+
+// Increment bumps the estimated frequency of h, saturating each row's
+// counter at 15, and halves every row once sampleSize increments have
+// accumulated so the sketch ages out stale frequency data.
+func (s *cmSketch) Increment(h uint64) {
+	for row := range s.rows {
+		idx := s.index(row, h)
+		byteIdx := idx / 2
+		shift := uint((idx % 2) * 4)
+		cur := (s.rows[row][byteIdx] >> shift) & 0x0f
+		if cur < 15 {
+			s.rows[row][byteIdx] += 1 << shift
+		}
+	}
+	s.additions++
+	if s.additions >= s.sampleSize {
+		s.reset()
+	}
+}
+
+// Estimate returns the minimum of the cmDepth counters for h, i.e. the
+// sketch's best guess at how often h has been seen.
+func (s *cmSketch) Estimate(h uint64) byte {
+	var min byte = 15
+	for row := range s.rows {
+		idx := s.index(row, h)
+		byteIdx := idx / 2
+		shift := uint((idx % 2) * 4)
+		cur := (s.rows[row][byteIdx] >> shift) & 0x0f
+		if cur < min {
+			min = cur
+		}
+	}
+	return min
+}
+
+func (s *cmSketch) reset() {
+	s.additions = 0
+	for row := range s.rows {
+		for i := range s.rows[row] {
+			s.rows[row][i] = (s.rows[row][i] >> 1) & 0x77
+		}
+	}
+}
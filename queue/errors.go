@@ -0,0 +1,31 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "errors"
+
+var (
+	// ErrDisposed is returned by a blocking or non-blocking call made
+	// against a ring that has had Dispose called on it, whether the
+	// call was already in progress when Dispose was called or made
+	// afterward.
+	ErrDisposed = errors.New("queue: disposed")
+
+	// ErrTimeout is returned by a Poll call that waited out its timeout
+	// without the requested item(s) becoming available.
+	ErrTimeout = errors.New("queue: poll timed out")
+)
@@ -18,6 +18,7 @@ package queue
 
 import (
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -51,13 +52,18 @@ type nodes []node
 // with some minor additions.
 type RingBuffer struct {
 	_padding0      [8]uint64
-	tail           uint64 		// 队尾指针
+	tail           uint64 // 队尾指针
 	_padding1      [8]uint64
-	head           uint64 		// 队头指针
+	head           uint64 // 队头指针
 	_padding2      [8]uint64
 	mask, disposed uint64
 	_padding3      [8]uint64
 	nodes          nodes
+	backoff        *backoff // nil unless created via NewRingBufferWithOptions
+
+	consumerCount uint64     // atomic; fast path to skip consumersMu when 0
+	consumersMu   sync.Mutex // guards consumers
+	consumers     []*uint64  // cursor of every registered fan-out Consumer
 }
 
 func (rb *RingBuffer) init(size uint64) {
@@ -85,27 +91,36 @@ func (rb *RingBuffer) Offer(item interface{}) (bool, error) {
 }
 
 // 入队函数
-// 	1. 获取插入的位置 pos = rb.tail
-//	2. 获取 pos 处的 buffer node, 即 n = &rb.nodes[pos&rb.mask]
-//	3. 判断 pos 是否等于 n.position
-//		3.1 若相等，尝试占领 pos 这个位置（cas），让 rb.tail 加一，跳出循环
-//		3.2 若 n.position < rb.tail, 出错，panic
-//		3.3 若 n.position > rb.tail, 说明 n 处已经被写入数据，更新 pos , 重新进入第2步
+//  1. 获取插入的位置 pos = rb.tail
+//  2. 获取 pos 处的 buffer node, 即 n = &rb.nodes[pos&rb.mask]
+//  3. 判断 pos 是否等于 n.position
+//     3.1 若相等，尝试占领 pos 这个位置（cas），让 rb.tail 加一，跳出循环
+//     3.2 若 n.position < rb.tail, 出错，panic
+//     3.3 若 n.position > rb.tail, 说明 n 处已经被写入数据，更新 pos , 重新进入第2步
 //  4. 调用 atomic.StoreUint64(&n.position, tail+1) 将 n.position 置为 tail+1
 //
-//
-//
 // 疑问
-//  为何要通过 atomic.StoreUint64(&n.position, tail+1) 将 node 的 position 设为 pos+1 ？
+//
+//	为何要通过 atomic.StoreUint64(&n.position, tail+1) 将 node 的 position 设为 pos+1 ？
 //
 // 个人见解
-// 	主要作用是标记 pos 处已经放置数据了。
-// 	若其他线程获得相同的 pos ，当其再比较 pos 和 sequence 时将不会再相等，就不会再次在相同的 pos 处写入数据。
-// 	另外，此处的 pos+1 和出队时的判断 dif := seq - (pos + 1) 相对应。
 //
+//	主要作用是标记 pos 处已经放置数据了。
+//	若其他线程获得相同的 pos ，当其再比较 pos 和 sequence 时将不会再相等，就不会再次在相同的 pos 处写入数据。
+//	另外，此处的 pos+1 和出队时的判断 dif := seq - (pos + 1) 相对应。
 func (rb *RingBuffer) put(item interface{}, offer bool) (bool, error) {
+	// Fan-out Consumers (see ring_consumer.go) never advance n.position
+	// the way Get/Poll do, so the node-state protocol below can never
+	// detect a slot as free again once one has been registered: it
+	// would spin forever past the first lap. putFanOut instead keys
+	// slot reclamation entirely off the slowest Consumer's cursor.
+	if atomic.LoadUint64(&rb.consumerCount) > 0 {
+		return rb.putFanOut(item, offer)
+	}
+
 	var n *node
 	tail := atomic.LoadUint64(&rb.tail)
+	attempt := 0
 L:
 	for {
 
@@ -133,11 +148,22 @@ L:
 			return false, nil
 		}
 
-		runtime.Gosched() // free up the cpu before the next iteration
+		if rb.backoff == nil {
+			runtime.Gosched() // free up the cpu before the next iteration
+		} else {
+			waitTail, waitNode := tail, n
+			rb.backoff.wait(attempt, rb.backoff.notFull, time.Time{}, func() bool {
+				return atomic.LoadUint64(&waitNode.position)-waitTail != 0
+			})
+			attempt++
+		}
 	}
 
 	n.data = item
 	atomic.StoreUint64(&n.position, tail+1)
+	if rb.backoff != nil {
+		rb.backoff.signalNotEmpty()
+	}
 	return true, nil
 }
 
@@ -155,27 +181,27 @@ func (rb *RingBuffer) Get() (interface{}, error) {
 // error will be returned if the tail is disposed or a timeout occurs. A
 // non-positive timeout will block indefinitely.
 //
-//
-//
-// 1. 获取出队位置 pos = rb.head
-// 2. 获取 pos 处的 node
-// 3. 判断 pos + 1 是否等于 node.position
-//	3.1 若相等，则 node 上包含数据，尝试弹出 pos 这个位置（case ），让 rb.head 加一，跳出循环
-// 	3.2 若 node.position < pos + 1 , 出错，panic
-//  3.3 若 node.position > pos + 1 , 说明 pos 处数据已经出队，更新 pos , 重新进入第2步
-//
+//  1. 获取出队位置 pos = rb.head
+//  2. 获取 pos 处的 node
+//  3. 判断 pos + 1 是否等于 node.position
+//     3.1 若相等，则 node 上包含数据，尝试弹出 pos 这个位置（case ），让 rb.head 加一，跳出循环
+//     3.2 若 node.position < pos + 1 , 出错，panic
+//     3.3 若 node.position > pos + 1 , 说明 pos 处数据已经出队，更新 pos , 重新进入第2步
 func (rb *RingBuffer) Poll(timeout time.Duration) (interface{}, error) {
 
 	var (
-		n     *node
-		pos   = atomic.LoadUint64(&rb.head)
-		start time.Time
+		n        *node
+		pos      = atomic.LoadUint64(&rb.head)
+		start    time.Time
+		deadline time.Time
 	)
 
 	if timeout > 0 {
 		start = time.Now()
+		deadline = start.Add(timeout)
 	}
 
+	attempt := 0
 L:
 	for {
 		if atomic.LoadUint64(&rb.disposed) == 1 {
@@ -199,11 +225,22 @@ L:
 			return nil, ErrTimeout
 		}
 
-		runtime.Gosched() // free up the cpu before the next iteration
+		if rb.backoff == nil {
+			runtime.Gosched() // free up the cpu before the next iteration
+		} else {
+			waitPos, waitNode := pos, n
+			rb.backoff.wait(attempt, rb.backoff.notEmpty, deadline, func() bool {
+				return atomic.LoadUint64(&waitNode.position)-(waitPos+1) != 0
+			})
+			attempt++
+		}
 	}
 	data := n.data
 	n.data = nil
 	atomic.StoreUint64(&n.position, pos+rb.mask+1)
+	if rb.backoff != nil {
+		rb.backoff.signalNotFull()
+	}
 	return data, nil
 }
 
@@ -222,6 +259,10 @@ func (rb *RingBuffer) Cap() uint64 {
 // tail will return an error.
 func (rb *RingBuffer) Dispose() {
 	atomic.CompareAndSwapUint64(&rb.disposed, 0, 1)
+	if rb.backoff != nil {
+		rb.backoff.signalNotEmpty()
+		rb.backoff.signalNotFull()
+	}
 }
 
 // IsDisposed will return a bool indicating if this tail has been
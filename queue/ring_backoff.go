@@ -0,0 +1,171 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// ParkStrategy controls what a RingBuffer does once a blocked Put or Get
+// has spun past its SpinCount without making progress.
+type ParkStrategy int
+
+const (
+	// ParkStrategySpin keeps calling runtime.Gosched() forever.  This is
+	// the original, unconditional behavior of RingBuffer and burns a CPU
+	// core for the full duration of a long empty or full period.
+	ParkStrategySpin ParkStrategy = iota
+
+	// ParkStrategySleep backs off with exponentially increasing
+	// time.Sleep calls, capped at MaxParkDuration.
+	ParkStrategySleep
+
+	// ParkStrategyCond parks the calling goroutine on a sync.Cond that
+	// is signaled by the opposite side (a Get wakes blocked Puts and
+	// vice versa), waking to retry after MaxParkDuration if no signal
+	// arrives first.
+	ParkStrategyCond
+)
+
+// RingBufferOptions configures the backoff a RingBuffer uses while a Put
+// or Get is blocked.  The zero value reproduces the original behavior:
+// an unconditional runtime.Gosched() on every failed attempt.
+type RingBufferOptions struct {
+	// SpinCount is the number of pure runtime.Gosched() spins attempted
+	// before escalating to ParkStrategy.
+	SpinCount int
+
+	// ParkStrategy selects what happens once SpinCount is exhausted.
+	ParkStrategy ParkStrategy
+
+	// MaxParkDuration caps the sleep duration used by ParkStrategySleep
+	// and how long ParkStrategyCond parks without a signal.  Defaults to
+	// 1ms if left zero.
+	MaxParkDuration time.Duration
+}
+
+// backoff implements the escalating wait policy described by a
+// RingBufferOptions.  A nil *backoff (the zero value used by
+// NewRingBuffer) means "always Gosched", matching the historical
+// RingBuffer behavior exactly.
+type backoff struct {
+	options  RingBufferOptions
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+}
+
+func newBackoff(options RingBufferOptions) *backoff {
+	if options.MaxParkDuration <= 0 {
+		options.MaxParkDuration = time.Millisecond
+	}
+	b := &backoff{options: options}
+	if options.ParkStrategy == ParkStrategyCond {
+		b.notEmpty = sync.NewCond(&sync.Mutex{})
+		b.notFull = sync.NewCond(&sync.Mutex{})
+	}
+	return b
+}
+
+// wait is called after attempt failed attempts (0-based) at making
+// progress, optionally parking on cond once the spin budget is spent.
+// deadline is the caller's overall timeout, or the zero Time if the
+// caller blocks indefinitely. stillBlocked re-tests the caller's
+// full/empty predicate; it is only consulted under cond.L, so a signal
+// racing the initial (unlocked) predicate check made by the caller can
+// never be missed: either it lands before we lock (stillBlocked then
+// sees it and we don't wait at all), or after (Wait() is already
+// registered to receive it).
+func (b *backoff) wait(attempt int, cond *sync.Cond, deadline time.Time, stillBlocked func() bool) {
+	if attempt < b.options.SpinCount {
+		runtime.Gosched()
+		return
+	}
+
+	switch b.options.ParkStrategy {
+	case ParkStrategyCond:
+		if cond != nil {
+			// cond.Wait has no built-in deadline, so a timer broadcasts
+			// the cond once parkUntil passes, waking a Wait that no real
+			// signal ever would have. parkUntil is MaxParkDuration from
+			// now, or the caller's deadline if that is sooner. The loop
+			// condition re-checks parkUntil right after every wake (real
+			// or forced), so the single forced broadcast is all that's
+			// needed even though cond.Wait may be called more than once
+			// before then.
+			parkUntil := time.Now().Add(b.options.MaxParkDuration)
+			if !deadline.IsZero() && deadline.Before(parkUntil) {
+				parkUntil = deadline
+			}
+			if d := time.Until(parkUntil); d > 0 {
+				timer := time.AfterFunc(d, func() {
+					cond.L.Lock()
+					cond.Broadcast()
+					cond.L.Unlock()
+				})
+				defer timer.Stop()
+			}
+			cond.L.Lock()
+			for stillBlocked() && time.Now().Before(parkUntil) {
+				cond.Wait()
+			}
+			cond.L.Unlock()
+			return
+		}
+		fallthrough
+	case ParkStrategySleep:
+		shift := attempt - b.options.SpinCount
+		if shift > 20 {
+			shift = 20 // avoid overflowing the duration shift
+		}
+		d := time.Millisecond << uint(shift)
+		if d <= 0 || d > b.options.MaxParkDuration {
+			d = b.options.MaxParkDuration
+		}
+		time.Sleep(d)
+	default:
+		runtime.Gosched()
+	}
+}
+
+func (b *backoff) signalNotEmpty() {
+	if b.notEmpty != nil {
+		b.notEmpty.L.Lock()
+		b.notEmpty.Broadcast()
+		b.notEmpty.L.Unlock()
+	}
+}
+
+func (b *backoff) signalNotFull() {
+	if b.notFull != nil {
+		b.notFull.L.Lock()
+		b.notFull.Broadcast()
+		b.notFull.L.Unlock()
+	}
+}
+
+// NewRingBufferWithOptions will allocate, initialize, and return a ring
+// buffer with the specified size whose blocked Put/Get calls back off
+// according to options instead of unconditionally calling
+// runtime.Gosched().
+func NewRingBufferWithOptions(size uint64, options RingBufferOptions) *RingBuffer {
+	rb := &RingBuffer{}
+	rb.init(size)
+	rb.backoff = newBackoff(options)
+	return rb
+}
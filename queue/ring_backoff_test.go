@@ -0,0 +1,87 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRingBufferParkStrategyCondNoLostWakeup guards against the window
+// between a blocked Put/Get's full/empty check and cond.Wait(): a
+// Broadcast racing that window must never be lost, or the blocked
+// caller parks forever even though the buffer state it was waiting on
+// already changed.
+func TestRingBufferParkStrategyCondNoLostWakeup(t *testing.T) {
+	rb := NewRingBufferWithOptions(2, RingBufferOptions{
+		SpinCount:    0,
+		ParkStrategy: ParkStrategyCond,
+	})
+
+	if err := rb.Put("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.Put("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	putDone := make(chan error, 1)
+	go func() {
+		putDone <- rb.Put("c")
+	}()
+
+	// Give the blocked Put time to reach cond.Wait() before the Get
+	// below signals notFull; without the fix, a Broadcast that fires
+	// after the Put's initial (unlocked) full-check but before Wait()
+	// registers would be missed entirely.
+	time.Sleep(20 * time.Millisecond)
+
+	if _, err := rb.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-putDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put never woke up after Get freed a slot; wakeup was lost")
+	}
+}
+
+// TestRingBufferParkStrategyCondPollRespectsTimeout guards against
+// backoff.wait's cond.Wait() loop ignoring Poll's timeout entirely: a
+// Poll with ParkStrategyCond and no concurrent Put must still return
+// ErrTimeout once its deadline passes, not block until one arrives.
+func TestRingBufferParkStrategyCondPollRespectsTimeout(t *testing.T) {
+	rb := NewRingBufferWithOptions(2, RingBufferOptions{
+		SpinCount:    0,
+		ParkStrategy: ParkStrategyCond,
+	})
+
+	start := time.Now()
+	_, err := rb.Poll(50 * time.Millisecond)
+	elapsed := time.Since(start)
+
+	if err != ErrTimeout {
+		t.Fatalf("Poll returned err=%v, want ErrTimeout", err)
+	}
+	if elapsed > time.Second {
+		t.Fatalf("Poll blocked for %s past its 50ms timeout", elapsed)
+	}
+}
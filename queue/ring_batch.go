@@ -0,0 +1,255 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// PutN adds the provided items to the tail as a single logical batch. It
+// blocks until every item has been added or Dispose is called on the
+// buffer, reserving each run of up to Cap() slots with one CAS on tail
+// (rather than one CAS per item), which amortizes the contention cost
+// across len(items) puts. A batch larger than Cap() is simply delivered
+// as more than one such reservation; PutN never drops an item.
+//
+// The run of slots claimed by a single reservation may wrap around the
+// end of the underlying array; that is transparent here because slots
+// are always addressed modulo the ring's mask.
+func (rb *RingBuffer) PutN(items []interface{}) (int, error) {
+	var total int
+	for len(items) > 0 {
+		n, err := rb.putN(items, false)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		items = items[n:]
+	}
+	return total, nil
+}
+
+// OfferN behaves like PutN but never blocks: if fewer than len(items)
+// slots are free, it claims as many as it can (possibly zero, and never
+// more than Cap()) and returns immediately.
+func (rb *RingBuffer) OfferN(items []interface{}) (int, error) {
+	return rb.putN(items, true)
+}
+
+// putN reserves and fills at most min(len(items), Cap()) slots in a
+// single CAS-on-tail reservation.
+func (rb *RingBuffer) putN(items []interface{}, offer bool) (int, error) {
+	if len(items) == 0 {
+		return 0, nil
+	}
+	capacity := uint64(len(rb.nodes))
+	requested := uint64(len(items))
+	if requested > capacity {
+		requested = capacity
+	}
+
+	fanOut := atomic.LoadUint64(&rb.consumerCount) > 0
+
+	var tail, n uint64
+	attempt := 0
+L:
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return 0, ErrDisposed
+		}
+
+		// Recompute the request size from scratch every attempt: a
+		// previous iteration may have shrunk n down to whatever was
+		// free at the time, but a concurrent Get/Consumer may have
+		// freed more room since, and the caller asked for up to
+		// `requested` items, not whatever we settled for last time.
+		n = requested
+		tail = atomic.LoadUint64(&rb.tail)
+		free := rb.freeSlots(tail, n, fanOut, capacity)
+
+		switch {
+		case free == n:
+			if atomic.CompareAndSwapUint64(&rb.tail, tail, tail+n) {
+				break L
+			}
+		case free > 0 && offer:
+			n = free
+			if atomic.CompareAndSwapUint64(&rb.tail, tail, tail+n) {
+				break L
+			}
+		case offer:
+			return 0, nil
+		}
+
+		if rb.backoff == nil {
+			runtime.Gosched()
+		} else {
+			waitTail, waitN := tail, n
+			rb.backoff.wait(attempt, rb.backoff.notFull, time.Time{}, func() bool {
+				return rb.freeSlots(waitTail, waitN, fanOut, capacity) < waitN
+			})
+			attempt++
+		}
+	}
+
+	items = items[:n]
+	for i, item := range items {
+		slot := &rb.nodes[(tail+uint64(i))&rb.mask]
+		slot.data = item
+		atomic.StoreUint64(&slot.position, tail+uint64(i)+1)
+	}
+	if rb.backoff != nil {
+		rb.backoff.signalNotEmpty()
+	}
+	return len(items), nil
+}
+
+// freeSlots reports how many of the n slots starting at tail (capped at
+// capacity) are currently free to claim.
+func (rb *RingBuffer) freeSlots(tail, n uint64, fanOut bool, capacity uint64) uint64 {
+	if fanOut {
+		// Fan-out Consumers don't destructively advance n.position, so
+		// the per-slot scan below would never see a slot as "free"
+		// again after the first lap. Room is instead governed by how
+		// far behind the slowest Consumer is.
+		min, ok := rb.minConsumerCursor()
+		if !ok {
+			return n
+		}
+		var free uint64
+		if tail-min < capacity {
+			free = capacity - (tail - min)
+		}
+		if free > n {
+			free = n
+		}
+		return free
+	}
+
+	var free uint64
+	for free < n {
+		slot := &rb.nodes[(tail+free)&rb.mask]
+		if atomic.LoadUint64(&slot.position)-(tail+free) != 0 {
+			break
+		}
+		free++
+	}
+	return free
+}
+
+// GetN fills dst with the next len(dst) items from the tail, blocking
+// until all of them are available or Dispose is called on the buffer.
+// It claims each run of up to Cap() slots with a single CAS on head; a
+// dst larger than Cap() is simply filled by more than one such claim.
+func (rb *RingBuffer) GetN(dst []interface{}) (int, error) {
+	var total int
+	for len(dst) > 0 {
+		n, err := rb.getN(dst, false)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		dst = dst[n:]
+	}
+	return total, nil
+}
+
+// PollN behaves like GetN but never blocks: if fewer than len(dst) items
+// are available, it drains as many as it can (possibly zero, and never
+// more than Cap()) and returns immediately.
+func (rb *RingBuffer) PollN(dst []interface{}) (int, error) {
+	return rb.getN(dst, true)
+}
+
+// getN claims and drains at most min(len(dst), Cap()) slots in a single
+// CAS-on-head reservation.
+func (rb *RingBuffer) getN(dst []interface{}, poll bool) (int, error) {
+	if len(dst) == 0 {
+		return 0, nil
+	}
+	capacity := uint64(len(rb.nodes))
+	requested := uint64(len(dst))
+	if requested > capacity {
+		requested = capacity
+	}
+
+	var head, n uint64
+	attempt := 0
+L:
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return 0, ErrDisposed
+		}
+
+		// See the matching comment in putN: always recompute from the
+		// original request size, never carry a shrunk n forward.
+		n = requested
+		head = atomic.LoadUint64(&rb.head)
+		avail := rb.availSlots(head, n)
+
+		switch {
+		case avail == n:
+			if atomic.CompareAndSwapUint64(&rb.head, head, head+n) {
+				break L
+			}
+		case avail > 0 && poll:
+			n = avail
+			if atomic.CompareAndSwapUint64(&rb.head, head, head+n) {
+				break L
+			}
+		case poll:
+			return 0, nil
+		}
+
+		if rb.backoff == nil {
+			runtime.Gosched()
+		} else {
+			waitHead, waitN := head, n
+			rb.backoff.wait(attempt, rb.backoff.notEmpty, time.Time{}, func() bool {
+				return rb.availSlots(waitHead, waitN) < waitN
+			})
+			attempt++
+		}
+	}
+
+	for i := uint64(0); i < n; i++ {
+		slot := &rb.nodes[(head+i)&rb.mask]
+		dst[i] = slot.data
+		slot.data = nil
+		atomic.StoreUint64(&slot.position, head+i+rb.mask+1)
+	}
+	if rb.backoff != nil {
+		rb.backoff.signalNotFull()
+	}
+	return int(n), nil
+}
+
+// availSlots reports how many of the n slots starting at head are
+// currently available to claim.
+func (rb *RingBuffer) availSlots(head, n uint64) uint64 {
+	var avail uint64
+	for avail < n {
+		slot := &rb.nodes[(head+avail)&rb.mask]
+		if atomic.LoadUint64(&slot.position)-(head+avail+1) != 0 {
+			break
+		}
+		avail++
+	}
+	return avail
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import "testing"
+
+const benchBatchSize = 64
+
+// BenchmarkPutGetPerItem is the baseline: one CAS-on-tail per Put and
+// one CAS-on-head per Get.
+func BenchmarkPutGetPerItem(b *testing.B) {
+	rb := NewRingBuffer(benchBatchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < benchBatchSize; j++ {
+			rb.Put(j)
+		}
+		for j := 0; j < benchBatchSize; j++ {
+			rb.Get()
+		}
+	}
+}
+
+// BenchmarkPutGetN reserves the whole batch with a single CAS in each
+// direction via PutN/GetN.
+func BenchmarkPutGetN(b *testing.B) {
+	rb := NewRingBuffer(benchBatchSize)
+	items := make([]interface{}, benchBatchSize)
+	for i := range items {
+		items[i] = i
+	}
+	dst := make([]interface{}, benchBatchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rb.PutN(items)
+		rb.GetN(dst)
+	}
+}
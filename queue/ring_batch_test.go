@@ -0,0 +1,165 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRingBufferPutNLargerThanCapBlocksUntilAllDelivered guards against
+// PutN truncating silently: a batch bigger than Cap() must eventually
+// deliver every item (draining as the consumer frees room), not just
+// whatever fit in the first reservation.
+func TestRingBufferPutNLargerThanCapBlocksUntilAllDelivered(t *testing.T) {
+	rb := NewRingBuffer(4)
+	items := make([]interface{}, 10)
+	for i := range items {
+		items[i] = i
+	}
+
+	done := make(chan struct{})
+	var n int
+	var err error
+	go func() {
+		n, err = rb.PutN(items)
+		close(done)
+	}()
+
+	got := make([]interface{}, 0, 10)
+	for len(got) < 10 {
+		v, gerr := rb.Get()
+		if gerr != nil {
+			t.Fatal(gerr)
+		}
+		got = append(got, v)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("PutN never returned after every item was drained")
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 10 {
+		t.Fatalf("PutN returned n=%d, want 10", n)
+	}
+	for i, v := range got {
+		if v != i {
+			t.Fatalf("got[%d] = %v, want %v", i, v, i)
+		}
+	}
+}
+
+// TestRingBufferPutNRecomputesRequestSizeEachAttempt guards putN's retry
+// loop: every attempt re-reads tail and re-checks room for the whole
+// originally requested batch, so a batch that does not fit when PutN is
+// called must still be claimed in full once concurrent Gets free enough
+// room, even after another producer has moved tail in the meantime.
+func TestRingBufferPutNRecomputesRequestSizeEachAttempt(t *testing.T) {
+	rb := NewRingBuffer(4)
+	if err := rb.Put("x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.Put("y"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only 2 of 4 slots are free, fewer than the 3 PutN asks for, so its
+	// first attempts find too little room and wait. A concurrent producer
+	// then claims one of those slots via a plain Put, moving tail.
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		rb.Put("z")
+	}()
+
+	// Free up the 2 already-occupied slots shortly after the batch
+	// request starts, so the eventual successful attempt has 3 slots
+	// free rather than the 2 that were free when PutN was called.
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rb.Get()
+		rb.Get()
+	}()
+
+	n, err := rb.PutN([]interface{}{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Fatalf("PutN(3 items) = %d, want 3", n)
+	}
+}
+
+// TestRingBufferPutNGetNRouteThroughBackoff guards against putN/getN's
+// blocking retry falling back to an unconditional runtime.Gosched() and
+// ignoring a RingBuffer's configured RingBufferOptions: a blocked PutN
+// past capacity and a blocked GetN on an empty buffer must still
+// complete once the opposite side signals, the same as Put/Get do, on a
+// buffer built with a non-default ParkStrategy.
+func TestRingBufferPutNGetNRouteThroughBackoff(t *testing.T) {
+	rb := NewRingBufferWithOptions(4, RingBufferOptions{
+		SpinCount:    0,
+		ParkStrategy: ParkStrategyCond,
+	})
+
+	items := make([]interface{}, 6)
+	for i := range items {
+		items[i] = i
+	}
+
+	putDone := make(chan error, 1)
+	go func() {
+		_, err := rb.PutN(items)
+		putDone <- err
+	}()
+
+	dst := make([]interface{}, 6)
+	getDone := make(chan error, 1)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		_, err := rb.GetN(dst)
+		getDone <- err
+	}()
+
+	select {
+	case err := <-putDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("PutN never woke up once GetN freed room; wakeup was lost")
+	}
+
+	select {
+	case err := <-getDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("GetN never woke up once PutN published items; wakeup was lost")
+	}
+
+	for i, v := range dst {
+		if v != i {
+			t.Fatalf("dst[%d] = %v, want %v", i, v, i)
+		}
+	}
+}
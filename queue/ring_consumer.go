@@ -0,0 +1,194 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// Consumer is a fan-out subscriber over a RingBuffer's nodes, in the
+// style of an LMAX Disruptor: every Consumer registered on a RingBuffer
+// observes every published item, rather than items being handed out to
+// exactly one caller the way Get/Poll do. Each Consumer tracks its own
+// read cursor, and Put will not let the producer lap a slot until the
+// slowest registered Consumer has read past it.
+//
+// A RingBuffer's Consumers and its Get/Poll methods both read from the
+// same nodes, but Get/Poll mutate node state to mark a slot free again
+// as soon as one caller has read it. Mixing Consumers with Get/Poll on
+// the same RingBuffer is therefore not meaningful: pick one consumption
+// model per buffer.
+type Consumer struct {
+	rb     *RingBuffer
+	cursor *uint64
+	closed uint64
+}
+
+// NewConsumer registers and returns a new fan-out Consumer over rb. The
+// Consumer starts reading from the buffer's current tail, i.e. it will
+// observe items published after it joins, not the backlog already in
+// the buffer.
+func (rb *RingBuffer) NewConsumer() *Consumer {
+	cursor := new(uint64)
+	atomic.StoreUint64(cursor, atomic.LoadUint64(&rb.tail))
+
+	rb.consumersMu.Lock()
+	rb.consumers = append(rb.consumers, cursor)
+	rb.consumersMu.Unlock()
+	atomic.AddUint64(&rb.consumerCount, 1)
+
+	return &Consumer{rb: rb, cursor: cursor}
+}
+
+// Next returns the next item published after this Consumer's cursor,
+// blocking until one is available or the underlying RingBuffer is
+// disposed or this Consumer is closed.
+func (c *Consumer) Next() (interface{}, error) {
+	return c.Poll(0)
+}
+
+// Poll behaves like Next but returns ErrTimeout if no new item is
+// published within timeout. A non-positive timeout blocks indefinitely.
+func (c *Consumer) Poll(timeout time.Duration) (interface{}, error) {
+	var start time.Time
+	if timeout > 0 {
+		start = time.Now()
+	}
+
+	pos := atomic.LoadUint64(c.cursor)
+	for {
+		if atomic.LoadUint64(&c.closed) == 1 {
+			return nil, ErrDisposed
+		}
+		if atomic.LoadUint64(&c.rb.disposed) == 1 {
+			return nil, ErrDisposed
+		}
+
+		n := &c.rb.nodes[pos&c.rb.mask]
+		if atomic.LoadUint64(&n.position)-(pos+1) == 0 {
+			// The data here is shared with every other Consumer, so it
+			// is read, not taken: unlike Get/Poll, a Consumer never
+			// clears n.data or advances n.position.
+			data := n.data
+			atomic.StoreUint64(c.cursor, pos+1)
+			return data, nil
+		}
+
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, ErrTimeout
+		}
+		runtime.Gosched()
+	}
+}
+
+// Close deregisters this Consumer, letting the producer reclaim slots
+// it had not yet read without waiting on it any longer.
+func (c *Consumer) Close() {
+	if !atomic.CompareAndSwapUint64(&c.closed, 0, 1) {
+		return
+	}
+
+	c.rb.consumersMu.Lock()
+	defer c.rb.consumersMu.Unlock()
+	for i, cur := range c.rb.consumers {
+		if cur == c.cursor {
+			c.rb.consumers = append(c.rb.consumers[:i], c.rb.consumers[i+1:]...)
+			atomic.AddUint64(&c.rb.consumerCount, ^uint64(0)) // -1
+			break
+		}
+	}
+}
+
+// putFanOut is put's slot-claim strategy once one or more Consumers are
+// registered. Consumers read nodes in place rather than advancing
+// n.position the way Get/Poll do, so the position-equality check that
+// put uses in the no-Consumer case could never again observe a slot as
+// free past the first lap. Here, a slot is free once the slowest
+// Consumer has read past it, which aheadOfSlowestConsumer already
+// tracks; the claim itself is then a plain CAS on tail.
+func (rb *RingBuffer) putFanOut(item interface{}, offer bool) (bool, error) {
+	tail := atomic.LoadUint64(&rb.tail)
+	attempt := 0
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return false, ErrDisposed
+		}
+
+		if rb.aheadOfSlowestConsumer(tail) {
+			if offer {
+				return false, nil
+			}
+			if rb.backoff == nil {
+				runtime.Gosched()
+			} else {
+				waitTail := tail
+				rb.backoff.wait(attempt, rb.backoff.notFull, time.Time{}, func() bool {
+					return rb.aheadOfSlowestConsumer(waitTail)
+				})
+				attempt++
+			}
+			tail = atomic.LoadUint64(&rb.tail)
+			continue
+		}
+
+		if atomic.CompareAndSwapUint64(&rb.tail, tail, tail+1) {
+			break
+		}
+		tail = atomic.LoadUint64(&rb.tail)
+	}
+
+	n := &rb.nodes[tail&rb.mask]
+	n.data = item
+	atomic.StoreUint64(&n.position, tail+1)
+	if rb.backoff != nil {
+		rb.backoff.signalNotEmpty()
+	}
+	return true, nil
+}
+
+// aheadOfSlowestConsumer reports whether claiming the slot at tail would
+// lap a registered Consumer that hasn't read it yet.
+func (rb *RingBuffer) aheadOfSlowestConsumer(tail uint64) bool {
+	if atomic.LoadUint64(&rb.consumerCount) == 0 {
+		return false
+	}
+
+	min, ok := rb.minConsumerCursor()
+	if !ok {
+		return false
+	}
+	return tail-min >= uint64(len(rb.nodes))
+}
+
+func (rb *RingBuffer) minConsumerCursor() (uint64, bool) {
+	rb.consumersMu.Lock()
+	defer rb.consumersMu.Unlock()
+
+	if len(rb.consumers) == 0 {
+		return 0, false
+	}
+
+	min := atomic.LoadUint64(rb.consumers[0])
+	for _, cur := range rb.consumers[1:] {
+		if v := atomic.LoadUint64(cur); v < min {
+			min = v
+		}
+	}
+	return min, true
+}
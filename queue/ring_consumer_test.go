@@ -0,0 +1,165 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRingBufferPutWithConsumerDoesNotHangPastOneLap guards against
+// putFanOut regressing into the single-consumer reclaim protocol: once a
+// Consumer is registered, Put must keep accepting items past the first
+// wrap as long as the Consumer keeps draining, rather than hanging once
+// every slot has been written to once.
+func TestRingBufferPutWithConsumerDoesNotHangPastOneLap(t *testing.T) {
+	rb := NewRingBuffer(4)
+	c := rb.NewConsumer()
+
+	read := make(chan interface{}, 8)
+	go func() {
+		for i := 0; i < 8; i++ {
+			v, err := c.Next()
+			if err != nil {
+				return
+			}
+			read <- v
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 8; i++ {
+			if err := rb.Put(i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put blocked past the first lap even though the Consumer kept draining")
+	}
+
+	for i := 0; i < 8; i++ {
+		select {
+		case v := <-read:
+			if v != i {
+				t.Fatalf("Consumer read %v, want %v", v, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Consumer never observed item %d", i)
+		}
+	}
+}
+
+// TestRingBufferFanOutToMultipleConsumers guards the defining property of
+// the fan-out model: every registered Consumer observes every published
+// item independently, not just whichever one happens to read first.
+func TestRingBufferFanOutToMultipleConsumers(t *testing.T) {
+	rb := NewRingBuffer(4)
+	c1 := rb.NewConsumer()
+	c2 := rb.NewConsumer()
+
+	const n = 8
+	read := func(c *Consumer) <-chan interface{} {
+		out := make(chan interface{}, n)
+		go func() {
+			for i := 0; i < n; i++ {
+				v, err := c.Next()
+				if err != nil {
+					return
+				}
+				out <- v
+			}
+		}()
+		return out
+	}
+	read1 := read(c1)
+	read2 := read(c2)
+
+	go func() {
+		for i := 0; i < n; i++ {
+			if err := rb.Put(i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		select {
+		case v := <-read1:
+			if v != i {
+				t.Fatalf("c1 read %v, want %v", v, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("c1 never observed item %d", i)
+		}
+		select {
+		case v := <-read2:
+			if v != i {
+				t.Fatalf("c2 read %v, want %v", v, i)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("c2 never observed item %d", i)
+		}
+	}
+}
+
+// TestRingBufferConsumerCloseReclaimsSlot guards against a closed
+// Consumer permanently pinning the ring: putFanOut only withholds slots
+// from the slowest *registered* Consumer, so once a lagging Consumer
+// closes without ever reading, Put must stop waiting on it and reclaim
+// the slots it never consumed.
+func TestRingBufferConsumerCloseReclaimsSlot(t *testing.T) {
+	rb := NewRingBuffer(4)
+	lagging := rb.NewConsumer()
+
+	for i := 0; i < 4; i++ {
+		if err := rb.Put(i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The ring is full from lagging's perspective; a fifth Put would
+	// block on it.
+	putDone := make(chan error, 1)
+	go func() {
+		putDone <- rb.Put(4)
+	}()
+
+	select {
+	case err := <-putDone:
+		t.Fatalf("Put(4) returned %v before the lagging Consumer was closed; it should have blocked", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	lagging.Close()
+
+	select {
+	case err := <-putDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put never unblocked after the only registered Consumer was closed")
+	}
+}
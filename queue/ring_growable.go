@@ -0,0 +1,292 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// ring is the resizable backing store for a GrowableRingBuffer.  It is
+// swapped out wholesale (under the GrowableRingBuffer's resize lock)
+// whenever the buffer needs to grow, so that in-flight lock-free Put/Get
+// calls only ever see a single, consistent nodes/mask pair.
+type ring struct {
+	nodes nodes
+	mask  uint64
+}
+
+func newRing(size uint64) *ring {
+	size = roundUp(size)
+	r := &ring{nodes: make(nodes, size), mask: size - 1}
+	for i := uint64(0); i < size; i++ {
+		r.nodes[i] = node{position: i}
+	}
+	return r
+}
+
+// GrowableRingBuffer is a MPMC ring buffer like RingBuffer, except that a
+// Put which would otherwise block because the buffer is full instead
+// triggers the buffer to double in size.  It is appropriate for producers
+// that would rather pay for an occasional resize than block or spin
+// waiting on a consumer.
+//
+// Growth never loses data: every existing item is re-seated into the new,
+// larger nodes slice before the new ring is published, and Get calls take
+// a consistent snapshot of the current ring so they never observe a
+// partially resized buffer.
+type GrowableRingBuffer struct {
+	resizeLock sync.RWMutex
+
+	tail, head uint64
+	disposed   uint64
+	r          unsafe.Pointer // *ring, accessed via atomic.Load/StorePointer
+	max        uint64
+	backoff    *backoff // nil unless created via NewGrowableRingBufferWithOptions
+}
+
+// NewGrowableRingBuffer will allocate, initialize, and return a
+// GrowableRingBuffer that starts at the given initial capacity and will
+// double on demand up to, but never exceeding, max.  A max of 0 means the
+// buffer may grow without bound.
+func NewGrowableRingBuffer(initial, max uint64) *GrowableRingBuffer {
+	return newGrowableRingBuffer(initial, max, nil)
+}
+
+// NewGrowableRingBufferWithOptions is like NewGrowableRingBuffer, except
+// that Get (and Put once max is reached and growth is no longer
+// possible) back off according to options instead of unconditionally
+// calling runtime.Gosched() while blocked.
+func NewGrowableRingBufferWithOptions(initial, max uint64, options RingBufferOptions) *GrowableRingBuffer {
+	return newGrowableRingBuffer(initial, max, newBackoff(options))
+}
+
+func newGrowableRingBuffer(initial, max uint64, backoff *backoff) *GrowableRingBuffer {
+	rb := &GrowableRingBuffer{max: max, backoff: backoff}
+	atomic.StorePointer(&rb.r, unsafe.Pointer(newRing(initial)))
+	return rb
+}
+
+func (rb *GrowableRingBuffer) loadRing() *ring {
+	return (*ring)(atomic.LoadPointer(&rb.r))
+}
+
+// Put adds the provided item to the tail.  If the tail is full, the
+// buffer grows (doubling in size, up to max) rather than blocking.  Once
+// the buffer is at max capacity and full, Put blocks until a Get frees a
+// slot.  An error will be returned if the buffer is disposed.
+func (rb *GrowableRingBuffer) Put(item interface{}) error {
+	attempt := 0
+	for {
+		rb.resizeLock.RLock()
+		r := rb.loadRing()
+		tail := atomic.LoadUint64(&rb.tail)
+
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			rb.resizeLock.RUnlock()
+			return ErrDisposed
+		}
+
+		n := &r.nodes[tail&r.mask]
+		pos := atomic.LoadUint64(&n.position)
+		if pos == tail {
+			if atomic.CompareAndSwapUint64(&rb.tail, tail, tail+1) {
+				n.data = item
+				atomic.StoreUint64(&n.position, tail+1)
+				rb.resizeLock.RUnlock()
+				if rb.backoff != nil {
+					rb.backoff.signalNotEmpty()
+				}
+				return nil
+			}
+			rb.resizeLock.RUnlock()
+			continue
+		}
+
+		// The slot at tail still holds data Get hasn't consumed yet.
+		// That's only a real "full" buffer if head has nothing left to
+		// give it either; otherwise it's just a goroutine that lost the
+		// CAS above and needs to retry against a fresh tail. Note diff
+		// := pos - tail is a uint64, so it can never be negative here —
+		// comparing tail against head directly is what actually detects
+		// fullness.
+		head := atomic.LoadUint64(&rb.head)
+		full := tail-head >= uint64(len(r.nodes))
+		rb.resizeLock.RUnlock()
+
+		if !full {
+			continue
+		}
+
+		// The ring is full.  Grow it, unless we're already at the
+		// configured maximum, in which case we back off waiting for a
+		// Get to free up a slot.
+		grown, err := rb.grow(r)
+		if err != nil {
+			return err
+		}
+		if !grown {
+			if rb.backoff == nil {
+				runtime.Gosched()
+			} else {
+				rb.backoff.wait(attempt, rb.backoff.notFull, time.Time{}, func() bool {
+					head := atomic.LoadUint64(&rb.head)
+					tail := atomic.LoadUint64(&rb.tail)
+					return tail-head >= uint64(len(rb.loadRing().nodes))
+				})
+				attempt++
+			}
+		}
+	}
+}
+
+// grow doubles the capacity of the buffer, re-seating every live item by
+// its position into the new nodes slice.  If another goroutine already
+// replaced the ring snapshot the caller was looking at, it returns true
+// without growing so the caller retries against the new ring.  It is a
+// no-op returning false, nil if doubling would exceed max.
+func (rb *GrowableRingBuffer) grow(stale *ring) (bool, error) {
+	rb.resizeLock.Lock()
+	defer rb.resizeLock.Unlock()
+
+	current := rb.loadRing()
+	if current != stale {
+		// Someone else already grew the ring; the caller should retry
+		// against the new one.
+		return true, nil
+	}
+
+	newSize := uint64(len(current.nodes)) * 2
+	if rb.max != 0 && newSize > rb.max {
+		return false, nil
+	}
+
+	head := atomic.LoadUint64(&rb.head)
+	tail := atomic.LoadUint64(&rb.tail)
+	newMask := newSize - 1
+
+	// newRing's position-equals-index initialization only holds for a
+	// buffer whose tail starts at 0. Every slot not holding a live item
+	// carried over below must instead get the tail value that will next
+	// address it — the smallest v >= tail with v&newMask == i — or Put's
+	// pos==tail check can never match it again once tail advances past
+	// newSize, livelocking Put forever on a buffer that isn't actually
+	// full.
+	next := &ring{nodes: make(nodes, newSize), mask: newMask}
+	for i := uint64(0); i < newSize; i++ {
+		v := (tail &^ newMask) | i
+		if v < tail {
+			v += newSize
+		}
+		next.nodes[i] = node{position: v}
+	}
+
+	for pos := head; pos != tail; pos++ {
+		old := &current.nodes[pos&current.mask]
+		n := &next.nodes[pos&next.mask]
+		n.data = old.data
+		n.position = pos + 1
+	}
+
+	atomic.StorePointer(&rb.r, unsafe.Pointer(next))
+	return true, nil
+}
+
+// Get will return the next item in the tail.  This call will block if
+// the tail is empty.  This call will unblock when an item is added to
+// the tail or Dispose is called on the tail.  An error will be returned
+// if the tail is disposed.
+func (rb *GrowableRingBuffer) Get() (interface{}, error) {
+	attempt := 0
+	for {
+		rb.resizeLock.RLock()
+		r := rb.loadRing()
+		pos := atomic.LoadUint64(&rb.head)
+
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			rb.resizeLock.RUnlock()
+			return nil, ErrDisposed
+		}
+
+		n := &r.nodes[pos&r.mask]
+		seq := atomic.LoadUint64(&n.position)
+		if seq == pos+1 {
+			if atomic.CompareAndSwapUint64(&rb.head, pos, pos+1) {
+				data := n.data
+				n.data = nil
+				atomic.StoreUint64(&n.position, pos+r.mask+1)
+				rb.resizeLock.RUnlock()
+				if rb.backoff != nil {
+					rb.backoff.signalNotFull()
+				}
+				return data, nil
+			}
+			rb.resizeLock.RUnlock()
+			continue
+		}
+
+		// Nothing published at pos yet (seq == pos, or a stale view from
+		// before a grow re-seated it): the buffer is empty from here,
+		// back off instead of busy-spinning RLock/RUnlock.
+		rb.resizeLock.RUnlock()
+		if rb.backoff == nil {
+			runtime.Gosched()
+		} else {
+			waitPos := pos
+			rb.backoff.wait(attempt, rb.backoff.notEmpty, time.Time{}, func() bool {
+				rb.resizeLock.RLock()
+				r := rb.loadRing()
+				seq := atomic.LoadUint64(&r.nodes[waitPos&r.mask].position)
+				rb.resizeLock.RUnlock()
+				return seq != waitPos+1
+			})
+			attempt++
+		}
+	}
+}
+
+// Len returns the number of items currently in the buffer.
+func (rb *GrowableRingBuffer) Len() uint64 {
+	return atomic.LoadUint64(&rb.tail) - atomic.LoadUint64(&rb.head)
+}
+
+// Cap returns the current capacity of the buffer.  This changes over
+// time as the buffer grows.
+func (rb *GrowableRingBuffer) Cap() uint64 {
+	rb.resizeLock.RLock()
+	defer rb.resizeLock.RUnlock()
+	return uint64(len(rb.loadRing().nodes))
+}
+
+// Dispose will dispose of this buffer and free any blocked threads in
+// Put and/or Get.  Calling those methods on a disposed buffer will
+// return an error.
+func (rb *GrowableRingBuffer) Dispose() {
+	if atomic.CompareAndSwapUint64(&rb.disposed, 0, 1) && rb.backoff != nil {
+		rb.backoff.signalNotEmpty()
+		rb.backoff.signalNotFull()
+	}
+}
+
+// IsDisposed will return a bool indicating if this buffer has been
+// disposed.
+func (rb *GrowableRingBuffer) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestGrowableRingBufferGrowsPastInitialCapacity guards against the
+// full-detection regressing into dead code: Put must trigger grow()
+// once the initial capacity fills, not spin forever.
+func TestGrowableRingBufferGrowsPastInitialCapacity(t *testing.T) {
+	rb := NewGrowableRingBuffer(2, 0)
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			if err := rb.Put(i); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put never returned; growth was not triggered")
+	}
+
+	if got, want := rb.Cap(), uint64(16); got != want {
+		t.Fatalf("Cap() = %d, want %d", got, want)
+	}
+	if got, want := rb.Len(), uint64(10); got != want {
+		t.Fatalf("Len() = %d, want %d", got, want)
+	}
+
+	for i := 0; i < 10; i++ {
+		v, err := rb.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != i {
+			t.Fatalf("Get() = %v, want %v", v, i)
+		}
+	}
+}
+
+// TestGrowableRingBufferGetBlocksOnEmpty guards against Get's empty-case
+// busy-spinning with no yield: a Get issued before any Put must still
+// return once one arrives, rather than spinning forever unobserved.
+func TestGrowableRingBufferGetBlocksOnEmpty(t *testing.T) {
+	rb := NewGrowableRingBuffer(2, 0)
+
+	type result struct {
+		v   interface{}
+		err error
+	}
+	got := make(chan result, 1)
+	go func() {
+		v, err := rb.Get()
+		got <- result{v, err}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := rb.Put("item"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-got:
+		if r.err != nil || r.v != "item" {
+			t.Fatalf("Get() = (%v, %v), want (\"item\", nil)", r.v, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Get never returned for an item put after it started waiting")
+	}
+}
+
+// TestGrowableRingBufferWithOptionsRoutesThroughBackoff guards against
+// Get, and Put once max is reached, falling back to an unconditional
+// runtime.Gosched() and ignoring a GrowableRingBuffer's configured
+// RingBufferOptions: a Get blocked on empty and a Put blocked at max
+// capacity must both complete once the opposite side signals, on a
+// buffer built with a non-default ParkStrategy.
+func TestGrowableRingBufferWithOptionsRoutesThroughBackoff(t *testing.T) {
+	rb := NewGrowableRingBufferWithOptions(2, 2, RingBufferOptions{
+		SpinCount:    0,
+		ParkStrategy: ParkStrategyCond,
+	})
+
+	type result struct {
+		v   interface{}
+		err error
+	}
+	got := make(chan result, 1)
+	go func() {
+		v, err := rb.Get()
+		got <- result{v, err}
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if err := rb.Put("item"); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case r := <-got:
+		if r.err != nil || r.v != "item" {
+			t.Fatalf("Get() = (%v, %v), want (\"item\", nil)", r.v, r.err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Get never woke up after Put published an item; wakeup was lost")
+	}
+
+	if err := rb.Put("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.Put("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	putDone := make(chan error, 1)
+	go func() {
+		putDone <- rb.Put("c")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := rb.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-putDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put never woke up after Get freed a slot at max capacity; wakeup was lost")
+	}
+}
+
+// TestGrowableRingBufferConcurrentPutGetAcrossResize exercises many
+// concurrent producers and consumers against a buffer that starts too
+// small to hold everything in flight, so it must grow one or more times
+// while Puts and Gets are both active. No item should be lost or
+// duplicated, and Len()/Cap() must stay consistent with what was
+// actually produced and consumed.
+func TestGrowableRingBufferConcurrentPutGetAcrossResize(t *testing.T) {
+	const (
+		producers    = 8
+		itemsPerProd = 500
+		total        = producers * itemsPerProd
+	)
+
+	rb := NewGrowableRingBuffer(4, 0)
+
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(base int) {
+			defer wg.Done()
+			for i := 0; i < itemsPerProd; i++ {
+				if err := rb.Put(base*itemsPerProd + i); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(p)
+	}
+
+	seen := make([]bool, total)
+	var seenMu sync.Mutex
+	remaining := total
+	const consumerCount = 4
+	var consumers sync.WaitGroup
+	consumers.Add(consumerCount)
+	for c := 0; c < consumerCount; c++ {
+		go func() {
+			defer consumers.Done()
+			for {
+				v, err := rb.Get()
+				if err != nil {
+					// Dispose was called once every item was drained.
+					return
+				}
+				idx := v.(int)
+				seenMu.Lock()
+				if seen[idx] {
+					seenMu.Unlock()
+					t.Errorf("item %d observed more than once", idx)
+					continue
+				}
+				seen[idx] = true
+				remaining--
+				done := remaining == 0
+				seenMu.Unlock()
+				if done {
+					// Every item is accounted for; unblock whichever
+					// other consumers are still parked in a Get on the
+					// now-permanently-empty buffer.
+					rb.Dispose()
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		consumers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("producers/consumers never finished; Put or Get is stuck")
+	}
+
+	seenMu.Lock()
+	defer seenMu.Unlock()
+	for i, ok := range seen {
+		if !ok {
+			t.Fatalf("item %d was never observed by any consumer", i)
+		}
+	}
+
+	if got := rb.Len(); got != 0 {
+		t.Fatalf("Len() = %d after draining every item, want 0", got)
+	}
+	if cap := rb.Cap(); cap < 4 || cap&(cap-1) != 0 {
+		t.Fatalf("Cap() = %d is not a power of 2 no smaller than the initial size", cap)
+	}
+}
@@ -0,0 +1,326 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// ttlNode is like node but additionally carries the nanosecond deadline
+// (UnixNano) after which the slot's data is considered expired. A
+// deadlineNanos of 0 means the item never expires.
+type ttlNode struct {
+	position      uint64
+	data          interface{}
+	deadlineNanos uint64
+}
+
+// TTLRingBuffer is a RingBuffer variant appropriate for using the ring as
+// a work queue with SLA deadlines: each Put may carry a deadline, and Get
+// transparently discards any entries whose deadline has passed instead
+// of handing them to a consumer. A background sweeper also walks expired
+// entries off the head on its own schedule, so a slow or absent consumer
+// doesn't let expired work pin up the ring.
+type TTLRingBuffer struct {
+	_padding0      [8]uint64
+	tail           uint64
+	_padding1      [8]uint64
+	head           uint64
+	_padding2      [8]uint64
+	mask, disposed uint64
+	_padding3      [8]uint64
+	nodes          []ttlNode
+	expired        uint64
+	backoff        *backoff // nil unless created via NewTTLRingBufferWithOptions
+
+	onExpire func(interface{})
+
+	sweepStop chan struct{}
+	sweepDone chan struct{}
+}
+
+// NewTTLRingBuffer allocates, initializes, and returns a TTLRingBuffer of
+// the given size. sweepInterval controls how often the background
+// sweeper checks the head of the ring for expired entries; a
+// non-positive sweepInterval disables the sweeper, leaving expiry
+// checks to happen only as a side effect of Get/Poll.
+func NewTTLRingBuffer(size uint64, sweepInterval time.Duration) *TTLRingBuffer {
+	return newTTLRingBuffer(size, sweepInterval, nil)
+}
+
+// NewTTLRingBufferWithOptions behaves like NewTTLRingBuffer, but a
+// blocked Put/Get backs off according to options instead of
+// unconditionally calling runtime.Gosched(), exactly as
+// NewRingBufferWithOptions does for a plain RingBuffer.
+func NewTTLRingBufferWithOptions(size uint64, sweepInterval time.Duration, options RingBufferOptions) *TTLRingBuffer {
+	return newTTLRingBuffer(size, sweepInterval, newBackoff(options))
+}
+
+func newTTLRingBuffer(size uint64, sweepInterval time.Duration, backoff *backoff) *TTLRingBuffer {
+	size = roundUp(size)
+	rb := &TTLRingBuffer{
+		nodes:   make([]ttlNode, size),
+		backoff: backoff,
+	}
+	for i := uint64(0); i < size; i++ {
+		rb.nodes[i] = ttlNode{position: i}
+	}
+	rb.mask = size - 1
+
+	if sweepInterval > 0 {
+		rb.sweepStop = make(chan struct{})
+		rb.sweepDone = make(chan struct{})
+		go rb.sweepLoop(sweepInterval)
+	}
+
+	return rb
+}
+
+// OnExpire registers a callback invoked, from the sweeper goroutine or
+// from whichever Get/Poll call discovers the expiry, with the data of
+// every entry that expires before being consumed. It is not safe to call
+// concurrently with Put/Get.
+func (rb *TTLRingBuffer) OnExpire(f func(interface{})) {
+	rb.onExpire = f
+}
+
+// ExpiredCount returns the number of entries that have been discarded
+// because their deadline passed before a Get/Poll reached them.
+func (rb *TTLRingBuffer) ExpiredCount() uint64 {
+	return atomic.LoadUint64(&rb.expired)
+}
+
+// Put adds item to the tail with no deadline; it will never expire.
+func (rb *TTLRingBuffer) Put(item interface{}) error {
+	return rb.PutWithDeadline(item, time.Time{})
+}
+
+// PutWithDeadline adds item to the tail, marking it to be discarded by
+// Get/Poll (and the background sweeper) once deadline has passed. A
+// zero deadline means the item never expires.
+func (rb *TTLRingBuffer) PutWithDeadline(item interface{}, deadline time.Time) error {
+	var deadlineNanos uint64
+	if !deadline.IsZero() {
+		deadlineNanos = uint64(deadline.UnixNano())
+	}
+
+	var n *ttlNode
+	tail := atomic.LoadUint64(&rb.tail)
+	attempt := 0
+L:
+	for {
+		if atomic.LoadUint64(&rb.disposed) == 1 {
+			return ErrDisposed
+		}
+
+		n = &rb.nodes[tail&rb.mask]
+		pos := atomic.LoadUint64(&n.position)
+		switch diff := pos - tail; {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&rb.tail, tail, tail+1) {
+				break L
+			}
+		case diff < 0:
+			panic(`Ring buffer in a compromised state during a put operation.`)
+		default:
+			tail = atomic.LoadUint64(&rb.tail)
+		}
+
+		if rb.backoff == nil {
+			runtime.Gosched()
+		} else {
+			waitTail, waitNode := tail, n
+			rb.backoff.wait(attempt, rb.backoff.notFull, time.Time{}, func() bool {
+				return atomic.LoadUint64(&waitNode.position)-waitTail != 0
+			})
+			attempt++
+		}
+	}
+
+	n.data = item
+	atomic.StoreUint64(&n.deadlineNanos, deadlineNanos)
+	atomic.StoreUint64(&n.position, tail+1)
+	if rb.backoff != nil {
+		rb.backoff.signalNotEmpty()
+	}
+	return nil
+}
+
+// Get returns the next live item in the tail, transparently discarding
+// (and, if OnExpire was set, reporting) any expired entries it passes
+// over. This call blocks if the tail has no live item.
+func (rb *TTLRingBuffer) Get() (interface{}, error) {
+	return rb.Poll(0)
+}
+
+// Poll behaves like Get but returns ErrTimeout if no live item becomes
+// available within timeout. A non-positive timeout blocks indefinitely.
+func (rb *TTLRingBuffer) Poll(timeout time.Duration) (interface{}, error) {
+	var (
+		n          *ttlNode
+		pos        = atomic.LoadUint64(&rb.head)
+		start      time.Time
+		deadlineAt time.Time
+	)
+
+	if timeout > 0 {
+		start = time.Now()
+		deadlineAt = start.Add(timeout)
+	}
+
+	attempt := 0
+	// The outer loop re-enters the CAS search below every time it pops
+	// an expired entry, so that an expired slot is simply skipped
+	// rather than handed back to the caller.
+	for {
+	L:
+		for {
+			if atomic.LoadUint64(&rb.disposed) == 1 {
+				return nil, ErrDisposed
+			}
+
+			n = &rb.nodes[pos&rb.mask]
+			seq := atomic.LoadUint64(&n.position)
+			switch dif := seq - (pos + 1); {
+			case dif == 0:
+				if atomic.CompareAndSwapUint64(&rb.head, pos, pos+1) {
+					break L
+				}
+			case dif < 0:
+				panic(`Ring buffer in compromised state during a get operation.`)
+			default:
+				pos = atomic.LoadUint64(&rb.head)
+			}
+
+			if timeout > 0 && time.Since(start) >= timeout {
+				return nil, ErrTimeout
+			}
+
+			if rb.backoff == nil {
+				runtime.Gosched()
+			} else {
+				waitPos, waitNode := pos, n
+				rb.backoff.wait(attempt, rb.backoff.notEmpty, deadlineAt, func() bool {
+					return atomic.LoadUint64(&waitNode.position)-(waitPos+1) != 0
+				})
+				attempt++
+			}
+		}
+
+		data := n.data
+		deadline := atomic.LoadUint64(&n.deadlineNanos)
+		n.data = nil
+		atomic.StoreUint64(&n.deadlineNanos, 0)
+		atomic.StoreUint64(&n.position, pos+rb.mask+1)
+		if rb.backoff != nil {
+			rb.backoff.signalNotFull()
+		}
+
+		if deadline == 0 || deadline > uint64(time.Now().UnixNano()) {
+			return data, nil
+		}
+
+		atomic.AddUint64(&rb.expired, 1)
+		if rb.onExpire != nil {
+			rb.onExpire(data)
+		}
+		if timeout > 0 && time.Since(start) >= timeout {
+			return nil, ErrTimeout
+		}
+		pos = atomic.LoadUint64(&rb.head)
+	}
+}
+
+// sweepLoop periodically walks expired entries off the head of the ring
+// so that expired work doesn't sit there pinning the buffer just because
+// no consumer happens to be calling Get.
+func (rb *TTLRingBuffer) sweepLoop(interval time.Duration) {
+	defer close(rb.sweepDone)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-rb.sweepStop:
+			return
+		case <-ticker.C:
+			rb.sweepExpired()
+		}
+	}
+}
+
+func (rb *TTLRingBuffer) sweepExpired() {
+	for {
+		head := atomic.LoadUint64(&rb.head)
+		tail := atomic.LoadUint64(&rb.tail)
+		if head == tail || atomic.LoadUint64(&rb.disposed) == 1 {
+			return
+		}
+
+		n := &rb.nodes[head&rb.mask]
+		if atomic.LoadUint64(&n.position)-(head+1) != 0 {
+			return // not yet published; nothing more to sweep right now
+		}
+
+		deadline := atomic.LoadUint64(&n.deadlineNanos)
+		if deadline == 0 || deadline > uint64(time.Now().UnixNano()) {
+			return // live entry at the head; stop
+		}
+
+		if atomic.CompareAndSwapUint64(&rb.head, head, head+1) {
+			data := n.data
+			n.data = nil
+			atomic.StoreUint64(&n.deadlineNanos, 0)
+			atomic.StoreUint64(&n.position, head+rb.mask+1)
+			atomic.AddUint64(&rb.expired, 1)
+			if rb.backoff != nil {
+				rb.backoff.signalNotFull()
+			}
+			if rb.onExpire != nil {
+				rb.onExpire(data)
+			}
+		}
+	}
+}
+
+// Len returns the number of items (live or not-yet-swept expired) in
+// the tail.
+func (rb *TTLRingBuffer) Len() uint64 {
+	return atomic.LoadUint64(&rb.tail) - atomic.LoadUint64(&rb.head)
+}
+
+// Cap returns the capacity of this ring buffer.
+func (rb *TTLRingBuffer) Cap() uint64 {
+	return uint64(len(rb.nodes))
+}
+
+// Dispose will dispose of this buffer, stop the background sweeper if
+// one is running, and free any blocked Get/Poll calls with an error.
+func (rb *TTLRingBuffer) Dispose() {
+	if atomic.CompareAndSwapUint64(&rb.disposed, 0, 1) && rb.sweepStop != nil {
+		close(rb.sweepStop)
+		<-rb.sweepDone
+	}
+}
+
+// IsDisposed will return a bool indicating if this buffer has been
+// disposed.
+func (rb *TTLRingBuffer) IsDisposed() bool {
+	return atomic.LoadUint64(&rb.disposed) == 1
+}
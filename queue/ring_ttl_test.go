@@ -0,0 +1,226 @@
+/*
+Copyright 2014 Workiva, LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package queue
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestTTLRingBufferGetSkipsExpiredEntries guards the core promise of the
+// TTL variant: Get must transparently discard an entry whose deadline
+// has already passed and hand back the next live one instead, reporting
+// the discard via ExpiredCount and OnExpire.
+func TestTTLRingBufferGetSkipsExpiredEntries(t *testing.T) {
+	rb := NewTTLRingBuffer(4, 0)
+
+	var expired []interface{}
+	rb.OnExpire(func(v interface{}) {
+		expired = append(expired, v)
+	})
+
+	if err := rb.PutWithDeadline("stale", time.Now().Add(-time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.Put("fresh"); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := rb.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "fresh" {
+		t.Fatalf("Get() = %v, want %q (the stale entry should have been skipped)", v, "fresh")
+	}
+	if got := rb.ExpiredCount(); got != 1 {
+		t.Fatalf("ExpiredCount() = %d, want 1", got)
+	}
+	if len(expired) != 1 || expired[0] != "stale" {
+		t.Fatalf("OnExpire callback saw %v, want [\"stale\"]", expired)
+	}
+}
+
+// TestTTLRingBufferSweeperReclaimsWithoutAConsumer guards against the
+// background sweeper regressing into a no-op: expired entries sitting at
+// the head must be reclaimed on the sweeper's own schedule even though
+// nothing is calling Get, so a slow/absent consumer can't let expired
+// work pin up the ring.
+func TestTTLRingBufferSweeperReclaimsWithoutAConsumer(t *testing.T) {
+	rb := NewTTLRingBuffer(2, 5*time.Millisecond)
+	defer rb.Dispose()
+
+	if err := rb.PutWithDeadline("stale-1", time.Now().Add(-time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.PutWithDeadline("stale-2", time.Now().Add(-time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for rb.ExpiredCount() < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := rb.ExpiredCount(); got != 2 {
+		t.Fatalf("ExpiredCount() = %d after waiting for the sweeper, want 2", got)
+	}
+
+	// Both slots the sweeper reclaimed must be puttable again without a
+	// Get ever having run.
+	if err := rb.Put("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.Put("b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestTTLRingBufferWithOptionsRoutesThroughBackoff guards against Put/Get
+// falling back to an unconditional runtime.Gosched() and ignoring a
+// TTLRingBuffer's configured RingBufferOptions: a blocked Put past
+// capacity and a blocked Get on an empty buffer must still complete once
+// the opposite side signals, on a buffer built with a non-default
+// ParkStrategy.
+func TestTTLRingBufferWithOptionsRoutesThroughBackoff(t *testing.T) {
+	rb := NewTTLRingBufferWithOptions(2, 0, RingBufferOptions{
+		SpinCount:    0,
+		ParkStrategy: ParkStrategyCond,
+	})
+
+	if err := rb.Put("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rb.Put("b"); err != nil {
+		t.Fatal(err)
+	}
+
+	putDone := make(chan error, 1)
+	go func() {
+		putDone <- rb.Put("c")
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if _, err := rb.Get(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-putDone:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Put never woke up after Get freed a slot; wakeup was lost")
+	}
+}
+
+// TestTTLRingBufferConcurrentPutGetSweep exercises concurrent
+// Put/PutWithDeadline, Get, and the background sweeper against the same
+// buffer, guarding against races between them over the CAS'd expiry
+// state. Every live item put in must eventually be observed either by a
+// Get or as an expiry.
+func TestTTLRingBufferConcurrentPutGetSweep(t *testing.T) {
+	const (
+		producers    = 4
+		itemsPerProd = 200
+		total        = producers * itemsPerProd
+	)
+
+	rb := NewTTLRingBuffer(16, time.Millisecond)
+	defer rb.Dispose()
+
+	var seenMu sync.Mutex
+	seen := 0
+
+	rb.OnExpire(func(interface{}) {
+		seenMu.Lock()
+		seen++
+		seenMu.Unlock()
+	})
+
+	var wg sync.WaitGroup
+	wg.Add(producers)
+	for p := 0; p < producers; p++ {
+		go func(base int) {
+			defer wg.Done()
+			for i := 0; i < itemsPerProd; i++ {
+				v := base*itemsPerProd + i
+				var err error
+				if v%2 == 0 {
+					err = rb.PutWithDeadline(v, time.Now().Add(time.Millisecond))
+				} else {
+					err = rb.Put(v)
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(p)
+	}
+
+	var consumers sync.WaitGroup
+	stop := make(chan struct{})
+	consumers.Add(2)
+	for c := 0; c < 2; c++ {
+		go func() {
+			defer consumers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := rb.Poll(10 * time.Millisecond); err == nil {
+					seenMu.Lock()
+					seen++
+					seenMu.Unlock()
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("producers never finished; Put is stuck")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		seenMu.Lock()
+		got := seen
+		seenMu.Unlock()
+		if got >= total {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("only %d/%d items were observed via Get/Poll or expiry", got, total)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	close(stop)
+	consumers.Wait()
+}